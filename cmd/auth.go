@@ -5,15 +5,28 @@
 package cmd
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
 
 	"golang.org/x/crypto/ssh/terminal"
 	"launchpad.net/gnuflag"
@@ -21,6 +34,11 @@ import (
 
 type userCreate struct{}
 
+type userCreateRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
 func (c *userCreate) Info() *Info {
 	return &Info{
 		Name:    "user-create",
@@ -36,25 +54,30 @@ func (c *userCreate) Run(context *Context, client *Client) error {
 		return err
 	}
 	email := context.Args[0]
-	fmt.Fprint(context.Stdout, "Password: ")
-	password, err := passwordFromReader(context.Stdin)
+	policy, err := fetchPasswordPolicy()
+	if err != nil {
+		return err
+	}
+	password, err := passwordFromReader(context, "Password: ", policy)
 	if err != nil {
 		return err
 	}
-	fmt.Fprint(context.Stdout, "\nConfirm: ")
-	confirm, err := passwordFromReader(context.Stdin)
+	confirm, err := passwordFromReader(context, "Confirm: ", nil)
 	if err != nil {
 		return err
 	}
-	fmt.Fprintln(context.Stdout)
 	if password != confirm {
 		return errors.New("Passwords didn't match.")
 	}
-	b := bytes.NewBufferString(`{"email":"` + email + `", "password":"` + password + `"}`)
-	request, err := http.NewRequest("POST", url, b)
+	b, err := json.Marshal(userCreateRequest{Email: email, Password: password})
+	if err != nil {
+		return err
+	}
+	request, err := newTokenScopedRequest("POST", url, bytes.NewReader(b))
 	if err != nil {
 		return err
 	}
+	request.Header.Set("Content-Type", "application/json")
 	resp, err := client.Do(request)
 	if resp != nil {
 		if resp.StatusCode == http.StatusNotFound ||
@@ -83,7 +106,7 @@ func (c *userRemove) Run(context *Context, client *Client) error {
 	if err != nil {
 		return err
 	}
-	request, err := http.NewRequest("DELETE", url, nil)
+	request, err := newTokenScopedRequest("DELETE", url, nil)
 	if err != nil {
 		return err
 	}
@@ -108,52 +131,279 @@ func (c *userRemove) Info() *Info {
 type loginScheme struct {
 	Name string
 	Data map[string]string
+	raw  []byte
+}
+
+// oidcSchemeSpec holds the oidc scheme's typed "data" fields, parsed from
+// raw since Data stays a map[string]string for the other schemes.
+type oidcSchemeSpec struct {
+	Issuer   string   `json:"issuer"`
+	ClientID string   `json:"client_id"`
+	Scopes   []string `json:"scopes"`
+}
+
+func parseOIDCSchemeSpec(raw []byte) (*oidcSchemeSpec, error) {
+	var wrapper struct {
+		Data oidcSchemeSpec `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &wrapper); err != nil {
+		return nil, err
+	}
+	return &wrapper.Data, nil
 }
 
 type login struct {
 	scheme *loginScheme
 }
 
+// oidcDiscovery is the subset of .well-known/openid-configuration we need.
+type oidcDiscovery struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+func discoverOIDC(issuer string) (*oidcDiscovery, error) {
+	resp, err := http.Get(trimTrailingSlash(issuer) + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to discover OIDC configuration for issuer %q: status %d", issuer, resp.StatusCode)
+	}
+	var disc oidcDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&disc); err != nil {
+		return nil, err
+	}
+	return &disc, nil
+}
+
+func trimTrailingSlash(s string) string {
+	if len(s) > 0 && s[len(s)-1] == '/' {
+		return s[:len(s)-1]
+	}
+	return s
+}
+
+func randomURLSafeString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// oidcLogin runs an OAuth2 authorization-code flow with PKCE, then trades
+// the resulting tokens for a tsuru token via /auth/login.
+func (c *login) oidcLogin(context *Context, client *Client) error {
+	data, err := parseOIDCSchemeSpec(c.getScheme().raw)
+	if err != nil {
+		return err
+	}
+	if data.Issuer == "" || data.ClientID == "" {
+		return errors.New("invalid oidc scheme: missing issuer or client_id")
+	}
+	disc, err := discoverOIDC(data.Issuer)
+	if err != nil {
+		return err
+	}
+	verifier, err := randomURLSafeString(32)
+	if err != nil {
+		return err
+	}
+	state, err := randomURLSafeString(16)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	redirectURI := fmt.Sprintf("http://127.0.0.1:%d/callback", listener.Addr().(*net.TCPAddr).Port)
+	codeCh := make(chan string, 1)
+	errCh := make(chan error, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("state") != state {
+			errCh <- errors.New("invalid state returned by identity provider")
+			http.Error(w, "invalid state", http.StatusBadRequest)
+			return
+		}
+		if errMsg := r.URL.Query().Get("error"); errMsg != "" {
+			errCh <- fmt.Errorf("authorization failed: %s", errMsg)
+			http.Error(w, errMsg, http.StatusBadRequest)
+			return
+		}
+		code := r.URL.Query().Get("code")
+		if code == "" {
+			errCh <- errors.New("authorization response missing code")
+			http.Error(w, "missing code", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintln(w, "Login successful, you can close this window and return to the terminal.")
+		codeCh <- code
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	defer server.Close()
+	scopes := data.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+	authURL := fmt.Sprintf("%s?%s", disc.AuthorizationEndpoint, url.Values{
+		"response_type":         {"code"},
+		"client_id":             {data.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {pkceChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}.Encode())
+	fmt.Fprintf(context.Stdout, "Opening browser to continue login, if it did not open please visit %q\n", authURL)
+	open(authURL)
+	var code string
+	select {
+	case code = <-codeCh:
+	case err = <-errCh:
+		return err
+	case <-time.After(5 * time.Minute):
+		return errors.New("timed out waiting for login callback")
+	}
+	tokenResp, err := exchangeOIDCCode(disc.TokenEndpoint, data.ClientID, code, verifier, redirectURI)
+	if err != nil {
+		return err
+	}
+	return c.finishOIDCLogin(client, tokenResp)
+}
+
+type oidcTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+}
+
+func exchangeOIDCCode(tokenEndpoint, clientID, code, verifier, redirectURI string) (*oidcTokenResponse, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {clientID},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+	resp, err := http.PostForm(tokenEndpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("token exchange failed: %s", string(body))
+	}
+	var tokenResp oidcTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return nil, err
+	}
+	return &tokenResp, nil
+}
+
+// parseLoginResponse extracts the tsuru token from a login response, shared
+// by the OIDC and native login flows.
+func parseLoginResponse(response *http.Response) (string, error) {
+	result, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	out := make(map[string]interface{})
+	if err := json.Unmarshal(result, &out); err != nil {
+		return "", err
+	}
+	if response.StatusCode != http.StatusOK {
+		if msg, ok := out["error"].(string); ok {
+			return "", errors.New(msg)
+		}
+		return "", fmt.Errorf("failed to exchange tokens with tsuru: status %d", response.StatusCode)
+	}
+	token, ok := out["token"].(string)
+	if !ok {
+		return "", errors.New("tsuru login response did not include a token")
+	}
+	return token, nil
+}
+
+func (c *login) finishOIDCLogin(client *Client, tokenResp *oidcTokenResponse) error {
+	url, err := GetURL("/auth/login")
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]string{
+		"id_token":     tokenResp.IDToken,
+		"access_token": tokenResp.AccessToken,
+	})
+	if err != nil {
+		return err
+	}
+	request, err := newTokenScopedRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	token, err := parseLoginResponse(response)
+	if err != nil {
+		return err
+	}
+	return writeToken(token)
+}
+
+type loginRequest struct {
+	Password string `json:"password"`
+}
+
 func nativeLogin(context *Context, client *Client) error {
 	email := context.Args[0]
-	fmt.Fprint(context.Stdout, "Password: ")
-	password, err := passwordFromReader(context.Stdin)
+	password, err := passwordFromReader(context, "Password: ", nil)
 	if err != nil {
 		return err
 	}
-	fmt.Fprintln(context.Stdout)
 	url, err := GetURL("/users/" + email + "/tokens")
 	if err != nil {
 		return err
 	}
-	b := bytes.NewBufferString(`{"password":"` + password + `"}`)
-	request, err := http.NewRequest("POST", url, b)
+	b, err := json.Marshal(loginRequest{Password: password})
 	if err != nil {
 		return err
 	}
-	response, err := client.Do(request)
+	request, err := newTokenScopedRequest("POST", url, bytes.NewReader(b))
 	if err != nil {
 		return err
 	}
-	defer response.Body.Close()
-	result, err := ioutil.ReadAll(response.Body)
+	request.Header.Set("Content-Type", "application/json")
+	response, err := client.Do(request)
 	if err != nil {
 		return err
 	}
-	out := make(map[string]interface{})
-	err = json.Unmarshal(result, &out)
+	defer response.Body.Close()
+	token, err := parseLoginResponse(response)
 	if err != nil {
 		return err
 	}
 	fmt.Fprintln(context.Stdout, "Successfully logged in!")
-	return writeToken(out["token"].(string))
+	return writeToken(token)
 }
 
 func (c *login) getScheme() *loginScheme {
 	if c.scheme == nil {
 		info, err := schemeInfo()
 		if err != nil {
-			c.scheme = &loginScheme{Name: "native", Data: make(map[string]string)}
+			c.scheme = &loginScheme{Name: "native"}
 		} else {
 			c.scheme = info
 		}
@@ -162,8 +412,11 @@ func (c *login) getScheme() *loginScheme {
 }
 
 func (c *login) Run(context *Context, client *Client) error {
-	if c.getScheme().Name == "oauth" {
+	switch c.getScheme().Name {
+	case "oauth":
 		return c.oauthLogin(context, client)
+	case "oidc":
+		return c.oidcLogin(context, client)
 	}
 	return nativeLogin(context, client)
 }
@@ -175,7 +428,7 @@ func (c *login) Name() string {
 func (c *login) Info() *Info {
 	args := 1
 	usage := "login <email>"
-	if c.getScheme().Name == "oauth" {
+	if name := c.getScheme().Name; name == "oauth" || name == "oidc" {
 		usage = "login"
 		args = 0
 	}
@@ -199,7 +452,7 @@ func (c *logout) Info() *Info {
 
 func (c *logout) Run(context *Context, client *Client) error {
 	if url, err := GetURL("/users/tokens"); err == nil {
-		request, _ := http.NewRequest("DELETE", url, nil)
+		request, _ := newTokenScopedRequest("DELETE", url, nil)
 		client.Do(request)
 	}
 	err := filesystem().Remove(JoinWithUserDir(".tsuru_token"))
@@ -212,6 +465,10 @@ func (c *logout) Run(context *Context, client *Client) error {
 
 type teamCreate struct{}
 
+type teamCreateRequest struct {
+	Name string `json:"name"`
+}
+
 func (c *teamCreate) Info() *Info {
 	return &Info{
 		Name:    "team-create",
@@ -223,15 +480,19 @@ func (c *teamCreate) Info() *Info {
 
 func (c *teamCreate) Run(context *Context, client *Client) error {
 	team := context.Args[0]
-	b := bytes.NewBufferString(fmt.Sprintf(`{"name":"%s"}`, team))
+	b, err := json.Marshal(teamCreateRequest{Name: team})
+	if err != nil {
+		return err
+	}
 	url, err := GetURL("/teams")
 	if err != nil {
 		return err
 	}
-	request, err := http.NewRequest("POST", url, b)
+	request, err := newTokenScopedRequest("POST", url, bytes.NewReader(b))
 	if err != nil {
 		return err
 	}
+	request.Header.Set("Content-Type", "application/json")
 	_, err = client.Do(request)
 	if err != nil {
 		return err
@@ -254,7 +515,7 @@ func (c *teamRemove) Run(context *Context, client *Client) error {
 	if err != nil {
 		return err
 	}
-	request, err := http.NewRequest("DELETE", url, nil)
+	request, err := newTokenScopedRequest("DELETE", url, nil)
 	if err != nil {
 		return err
 	}
@@ -292,7 +553,7 @@ func (c *teamUserAdd) Run(context *Context, client *Client) error {
 	if err != nil {
 		return err
 	}
-	request, err := http.NewRequest("PUT", url, nil)
+	request, err := newTokenScopedRequest("PUT", url, nil)
 	if err != nil {
 		return err
 	}
@@ -321,7 +582,7 @@ func (c *teamUserRemove) Run(context *Context, client *Client) error {
 	if err != nil {
 		return err
 	}
-	request, err := http.NewRequest("DELETE", url, nil)
+	request, err := newTokenScopedRequest("DELETE", url, nil)
 	if err != nil {
 		return err
 	}
@@ -341,7 +602,7 @@ func (teamUserList) Run(context *Context, client *Client) error {
 	if err != nil {
 		return err
 	}
-	request, err := http.NewRequest("GET", url, nil)
+	request, err := newTokenScopedRequest("GET", url, nil)
 	if err != nil {
 		return err
 	}
@@ -387,7 +648,7 @@ func (c *teamList) Run(context *Context, client *Client) error {
 	if err != nil {
 		return err
 	}
-	request, err := http.NewRequest("GET", url, nil)
+	request, err := newTokenScopedRequest("GET", url, nil)
 	if err != nil {
 		return err
 	}
@@ -416,43 +677,44 @@ func (c *teamList) Run(context *Context, client *Client) error {
 
 type changePassword struct{}
 
+type changePasswordRequest struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
 func (c *changePassword) Run(context *Context, client *Client) error {
 	url, err := GetURL("/users/password")
 	if err != nil {
 		return err
 	}
-	var body bytes.Buffer
-	fmt.Fprint(context.Stdout, "Current password: ")
-	old, err := passwordFromReader(context.Stdin)
+	policy, err := fetchPasswordPolicy()
 	if err != nil {
 		return err
 	}
-	fmt.Fprint(context.Stdout, "\nNew password: ")
-	new, err := passwordFromReader(context.Stdin)
+	old, err := passwordFromReader(context, "Current password: ", nil)
 	if err != nil {
 		return err
 	}
-	fmt.Fprint(context.Stdout, "\nConfirm: ")
-	confirm, err := passwordFromReader(context.Stdin)
+	new, err := passwordFromReader(context, "New password: ", policy)
+	if err != nil {
+		return err
+	}
+	confirm, err := passwordFromReader(context, "Confirm: ", nil)
 	if err != nil {
 		return err
 	}
-	fmt.Fprintln(context.Stdout)
 	if new != confirm {
 		return errors.New("New password and password confirmation didn't match.")
 	}
-	jsonBody := map[string]string{
-		"old": old,
-		"new": new,
-	}
-	err = json.NewEncoder(&body).Encode(jsonBody)
+	b, err := json.Marshal(changePasswordRequest{Old: old, New: new})
 	if err != nil {
 		return err
 	}
-	request, err := http.NewRequest("PUT", url, &body)
+	request, err := newTokenScopedRequest("PUT", url, bytes.NewReader(b))
 	if err != nil {
 		return err
 	}
+	request.Header.Set("Content-Type", "application/json")
 	_, err = client.Do(request)
 	if err != nil {
 		return err
@@ -513,7 +775,7 @@ func (c *resetPassword) Run(context *Context, client *Client) error {
 	if err != nil {
 		return err
 	}
-	request, _ := http.NewRequest("POST", url, nil)
+	request, _ := newTokenScopedRequest("POST", url, nil)
 	_, err = client.Do(request)
 	if err != nil {
 		return err
@@ -529,7 +791,7 @@ func (c *resetPassword) Flags() *gnuflag.FlagSet {
 	return fs
 }
 
-func passwordFromReader(reader io.Reader) (string, error) {
+func readPassword(reader io.Reader) (string, error) {
 	var (
 		password []byte
 		err      error
@@ -549,6 +811,141 @@ func passwordFromReader(reader io.Reader) (string, error) {
 	return string(password), err
 }
 
+// passwordFromReader re-prompts until the typed password satisfies policy.
+func passwordFromReader(context *Context, prompt string, policy *PasswordPolicy) (string, error) {
+	for {
+		fmt.Fprint(context.Stdout, prompt)
+		password, err := readPassword(context.Stdin)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintln(context.Stdout)
+		if err := validatePassword(policy, password); err != nil {
+			if checkErr, ok := err.(*passwordPolicyCheckError); ok {
+				return "", checkErr.err
+			}
+			fmt.Fprintf(context.Stdout, "Invalid password: %s\n", err)
+			continue
+		}
+		return password, nil
+	}
+}
+
+// passwordPolicyCheckError marks an infra failure (e.g. the common-password
+// blocklist being unreachable) so passwordFromReader stops re-prompting.
+type passwordPolicyCheckError struct {
+	err error
+}
+
+func (e *passwordPolicyCheckError) Error() string {
+	return e.err.Error()
+}
+
+// PasswordPolicy is fetched from /auth/password-policy.
+type PasswordPolicy struct {
+	MinLength              int      `json:"min_length"`
+	MaxLength              int      `json:"max_length"`
+	RequiredClasses        []string `json:"required_classes"`
+	CommonPasswordCheckURL string   `json:"common_password_check_url,omitempty"`
+}
+
+// fetchPasswordPolicy returns a nil policy, without error, when the server
+// does not expose one.
+func fetchPasswordPolicy() (*PasswordPolicy, error) {
+	url, err := GetURL("/auth/password-policy")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to load password policy: status %d", resp.StatusCode)
+	}
+	var policy PasswordPolicy
+	if err := json.NewDecoder(resp.Body).Decode(&policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func validatePassword(policy *PasswordPolicy, password string) error {
+	if policy == nil {
+		return nil
+	}
+	if policy.MinLength > 0 && len(password) < policy.MinLength {
+		return fmt.Errorf("must be at least %d characters long", policy.MinLength)
+	}
+	if policy.MaxLength > 0 && len(password) > policy.MaxLength {
+		return fmt.Errorf("must be at most %d characters long", policy.MaxLength)
+	}
+	for _, class := range policy.RequiredClasses {
+		if !passwordHasClass(password, class) {
+			return fmt.Errorf("must contain at least one %s character", class)
+		}
+	}
+	if policy.CommonPasswordCheckURL != "" {
+		common, err := isCommonPassword(policy.CommonPasswordCheckURL, password)
+		if err != nil {
+			return &passwordPolicyCheckError{err: err}
+		}
+		if common {
+			return errors.New("is too common, please choose a different one")
+		}
+	}
+	return nil
+}
+
+func passwordHasClass(password, class string) bool {
+	for _, r := range password {
+		switch class {
+		case "lower":
+			if unicode.IsLower(r) {
+				return true
+			}
+		case "upper":
+			if unicode.IsUpper(r) {
+				return true
+			}
+		case "digit":
+			if unicode.IsDigit(r) {
+				return true
+			}
+		case "symbol":
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isCommonPassword uses k-anonymity: only the first 5 hex chars of the
+// SHA-1 hash are sent, so the password itself never leaves the machine.
+func isCommonPassword(checkURL, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+	resp, err := http.Get(checkURL + "?prefix=" + prefix)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) == 2 && parts[0] == suffix {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
 func schemeInfo() (*loginScheme, error) {
 	url, err := GetURL("/auth/scheme")
 	if err != nil {
@@ -559,11 +956,19 @@ func schemeInfo() (*loginScheme, error) {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	info := loginScheme{}
-	err = json.NewDecoder(resp.Body).Decode(&info)
+	raw, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
+	info := loginScheme{raw: raw}
+	if err := json.Unmarshal(raw, &info); err != nil {
+		// Data stays a map[string]string, which schemes whose data isn't
+		// string-valued (e.g. oidc's array "scopes") can't fully populate;
+		// that's fine, their fields are read from raw separately.
+		if _, ok := err.(*json.UnmarshalTypeError); !ok {
+			return nil, err
+		}
+	}
 	return &info, nil
 }
 
@@ -583,7 +988,7 @@ func (c *showAPIToken) Run(context *Context, client *Client) error {
 	if err != nil {
 		return err
 	}
-	request, err := http.NewRequest("GET", url, nil)
+	request, err := newTokenScopedRequest("GET", url, nil)
 	if err != nil {
 		return err
 	}
@@ -623,7 +1028,7 @@ func (c *regenerateAPIToken) Run(context *Context, client *Client) error {
 	if err != nil {
 		return err
 	}
-	request, err := http.NewRequest("POST", url, nil)
+	request, err := newTokenScopedRequest("POST", url, nil)
 	if err != nil {
 		return err
 	}
@@ -646,3 +1051,676 @@ func (c *regenerateAPIToken) Run(context *Context, client *Client) error {
 	}
 	return nil
 }
+
+type UserStatus string
+
+const (
+	UserStatusActive    UserStatus = "active"
+	UserStatusSuspended UserStatus = "suspended"
+)
+
+const usersPerPage = 40
+
+// userInfo is a tsuru user, shared by user-list and user-info.
+type userInfo struct {
+	Email     string     `json:"email"`
+	CreatedAt time.Time  `json:"created_at"`
+	Status    UserStatus `json:"status"`
+	Teams     []string   `json:"teams"`
+	Roles     []string   `json:"roles"`
+}
+
+// outputJSON is the shared formatter for every --json output mode.
+func outputJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+type userList struct {
+	fs     *gnuflag.FlagSet
+	team   string
+	role   string
+	search string
+	json   bool
+}
+
+func (c *userList) Info() *Info {
+	return &Info{
+		Name:    "user-list",
+		Usage:   "user-list [--team/-t <teamname>] [--role/-r <role>] [--search/-s <query>] [--json]",
+		Desc:    "Lists users, optionally filtered by team, role or a search query.",
+		MinArgs: 0,
+	}
+}
+
+func (c *userList) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("user-list", gnuflag.ExitOnError)
+		c.fs.StringVar(&c.team, "team", "", "Filter users by team")
+		c.fs.StringVar(&c.team, "t", "", "Filter users by team")
+		c.fs.StringVar(&c.role, "role", "", "Filter users by role")
+		c.fs.StringVar(&c.role, "r", "", "Filter users by role")
+		c.fs.StringVar(&c.search, "search", "", "Filter users by a search query")
+		c.fs.StringVar(&c.search, "s", "", "Filter users by a search query")
+		c.fs.BoolVar(&c.json, "json", false, "Show JSON instead of a table")
+	}
+	return c.fs
+}
+
+func (c *userList) Run(context *Context, client *Client) error {
+	users, err := fetchUsers(client, c.team, c.role, c.search)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return outputJSON(context.Stdout, users)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Email < users[j].Email })
+	tw := tabwriter.NewWriter(context.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "Email\tStatus\tTeams\tRoles")
+	for _, u := range users {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", u.Email, u.Status, strings.Join(u.Teams, ", "), strings.Join(u.Roles, ", "))
+	}
+	return tw.Flush()
+}
+
+func fetchUsers(client *Client, team, role, search string) ([]userInfo, error) {
+	var users []userInfo
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("page", strconv.Itoa(page))
+		query.Set("per_page", strconv.Itoa(usersPerPage))
+		if team != "" {
+			query.Set("team", team)
+		}
+		if role != "" {
+			query.Set("role", role)
+		}
+		if search != "" {
+			query.Set("search", search)
+		}
+		url, err := GetURL("/users?" + query.Encode())
+		if err != nil {
+			return nil, err
+		}
+		request, err := newTokenScopedRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(request)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Users []userInfo `json:"users"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, result.Users...)
+		if len(result.Users) < usersPerPage {
+			break
+		}
+	}
+	return users, nil
+}
+
+type userInfoCmd struct {
+	fs   *gnuflag.FlagSet
+	json bool
+}
+
+func (c *userInfoCmd) Info() *Info {
+	return &Info{
+		Name:    "user-info",
+		Usage:   "user-info <email> [--json]",
+		Desc:    "Displays information about a user: email, creation date, status, teams and roles.",
+		MinArgs: 1,
+	}
+}
+
+func (c *userInfoCmd) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("user-info", gnuflag.ExitOnError)
+		c.fs.BoolVar(&c.json, "json", false, "Show JSON instead of the default output")
+	}
+	return c.fs
+}
+
+func (c *userInfoCmd) Run(context *Context, client *Client) error {
+	email := context.Args[0]
+	url, err := GetURL("/users/" + email)
+	if err != nil {
+		return err
+	}
+	request, err := newTokenScopedRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var u userInfo
+	if err := json.NewDecoder(resp.Body).Decode(&u); err != nil {
+		return err
+	}
+	if c.json {
+		return outputJSON(context.Stdout, u)
+	}
+	fmt.Fprintf(context.Stdout, "Email: %s\n", u.Email)
+	fmt.Fprintf(context.Stdout, "Created at: %s\n", u.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintf(context.Stdout, "Status: %s\n", u.Status)
+	fmt.Fprintf(context.Stdout, "Teams: %s\n", strings.Join(u.Teams, ", "))
+	fmt.Fprintf(context.Stdout, "Roles: %s\n", strings.Join(u.Roles, ", "))
+	return nil
+}
+
+func setUserStatus(context *Context, client *Client, email string, status UserStatus) error {
+	url, err := GetURL("/users/" + email + "/status")
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]UserStatus{"status": status})
+	if err != nil {
+		return err
+	}
+	request, err := newTokenScopedRequest("PUT", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	_, err = client.Do(request)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "User %q is now %s.\n", email, status)
+	return nil
+}
+
+type userSuspend struct{}
+
+func (c *userSuspend) Info() *Info {
+	return &Info{
+		Name:    "user-suspend",
+		Usage:   "user-suspend <email>",
+		Desc:    "Suspends a user, preventing them from authenticating.",
+		MinArgs: 1,
+	}
+}
+
+func (c *userSuspend) Run(context *Context, client *Client) error {
+	return setUserStatus(context, client, context.Args[0], UserStatusSuspended)
+}
+
+type userActivate struct{}
+
+func (c *userActivate) Info() *Info {
+	return &Info{
+		Name:    "user-activate",
+		Usage:   "user-activate <email>",
+		Desc:    "Activates a previously suspended user.",
+		MinArgs: 1,
+	}
+}
+
+func (c *userActivate) Run(context *Context, client *Client) error {
+	return setUserStatus(context, client, context.Args[0], UserStatusActive)
+}
+
+type userRoleGrant struct{}
+
+func (c *userRoleGrant) Info() *Info {
+	return &Info{
+		Name:    "user-role-grant",
+		Usage:   "user-role-grant <email> <role>",
+		Desc:    "Grants a role to a user.",
+		MinArgs: 2,
+	}
+}
+
+func (c *userRoleGrant) Run(context *Context, client *Client) error {
+	email, role := context.Args[0], context.Args[1]
+	url, err := GetURL(fmt.Sprintf("/users/%s/roles/%s", email, role))
+	if err != nil {
+		return err
+	}
+	request, err := newTokenScopedRequest("PUT", url, nil)
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Role %q successfully granted to user %q!\n", role, email)
+	return nil
+}
+
+type userRoleRevoke struct{}
+
+func (c *userRoleRevoke) Info() *Info {
+	return &Info{
+		Name:    "user-role-revoke",
+		Usage:   "user-role-revoke <email> <role>",
+		Desc:    "Revokes a role from a user.",
+		MinArgs: 2,
+	}
+}
+
+func (c *userRoleRevoke) Run(context *Context, client *Client) error {
+	email, role := context.Args[0], context.Args[1]
+	url, err := GetURL(fmt.Sprintf("/users/%s/roles/%s", email, role))
+	if err != nil {
+		return err
+	}
+	request, err := newTokenScopedRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Role %q successfully revoked from user %q!\n", role, email)
+	return nil
+}
+
+// tsuruTokenNameEnv selects, by name, a locally stored personal access
+// token to use instead of the default token written by login.
+const tsuruTokenNameEnv = "TSURU_TOKEN_NAME"
+
+// selectedTokenSecret returns ok=false when TSURU_TOKEN_NAME is unset.
+func selectedTokenSecret() (secret string, ok bool, err error) {
+	name := os.Getenv(tsuruTokenNameEnv)
+	if name == "" {
+		return "", false, nil
+	}
+	f, err := filesystem().Open(JoinWithUserDir(".tsuru_token-" + name))
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", false, err
+	}
+	return strings.TrimSpace(string(data)), true, nil
+}
+
+// newTokenScopedRequest is http.NewRequest plus the Authorization header
+// for the token selected via TSURU_TOKEN_NAME, if any; every request this
+// file sends through client is built with it, not just the token-* ones.
+// The unauthenticated calls (scheme, password policy, OIDC and SSO
+// endpoints) use plain http.Get/http.Post and carry no such header.
+func newTokenScopedRequest(method, url string, body io.Reader) (*http.Request, error) {
+	request, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	secret, ok, err := selectedTokenSecret()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		request.Header.Set("Authorization", "bearer "+secret)
+	}
+	return request, nil
+}
+
+// loginToken is a personal access token; Secret is only populated right
+// after creation, the server never returns it again afterwards.
+type loginToken struct {
+	ID          string     `json:"token_id"`
+	Description string     `json:"description"`
+	Scopes      []string   `json:"scopes,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Secret      string     `json:"token,omitempty"`
+}
+
+type tokenCreate struct {
+	fs          *gnuflag.FlagSet
+	description string
+	ttl         string
+	scope       string
+	json        bool
+}
+
+func (c *tokenCreate) Info() *Info {
+	return &Info{
+		Name:    "token-create",
+		Usage:   "token-create --description <description> [--ttl <duration>] [--scope <scope,scope>] [--json]",
+		Desc:    "Creates a new personal access token. The token secret is shown only once.",
+		MinArgs: 0,
+	}
+}
+
+func (c *tokenCreate) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("token-create", gnuflag.ExitOnError)
+		c.fs.StringVar(&c.description, "description", "", "A description for the token")
+		c.fs.StringVar(&c.description, "d", "", "A description for the token")
+		c.fs.StringVar(&c.ttl, "ttl", "", "How long the token should be valid for, e.g. 24h (default: never expires)")
+		c.fs.StringVar(&c.scope, "scope", "", "Comma separated list of API scopes the token is limited to (default: no limit)")
+		c.fs.BoolVar(&c.json, "json", false, "Show JSON instead of the default output")
+	}
+	return c.fs
+}
+
+type tokenCreateRequest struct {
+	Description string   `json:"description"`
+	ExpiresIn   float64  `json:"expires_in,omitempty"`
+	Scopes      []string `json:"scopes,omitempty"`
+}
+
+func (c *tokenCreate) Run(context *Context, client *Client) error {
+	reqBody := tokenCreateRequest{Description: c.description}
+	if c.ttl != "" {
+		d, err := time.ParseDuration(c.ttl)
+		if err != nil {
+			return fmt.Errorf("invalid --ttl %q: %s", c.ttl, err)
+		}
+		reqBody.ExpiresIn = d.Seconds()
+	}
+	if c.scope != "" {
+		reqBody.Scopes = strings.Split(c.scope, ",")
+	}
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return err
+	}
+	url, err := GetURL("/users/tokens")
+	if err != nil {
+		return err
+	}
+	request, err := newTokenScopedRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var token loginToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return err
+	}
+	if c.json {
+		return outputJSON(context.Stdout, token)
+	}
+	fmt.Fprintf(context.Stdout, "Token %q successfully created!\n", token.ID)
+	fmt.Fprintln(context.Stdout, "WARNING: this is the only time the token secret is shown, store it somewhere safe.")
+	fmt.Fprintf(context.Stdout, "Token: %s\n", token.Secret)
+	return nil
+}
+
+type tokenList struct {
+	fs   *gnuflag.FlagSet
+	json bool
+}
+
+func (c *tokenList) Info() *Info {
+	return &Info{
+		Name:    "token-list",
+		Usage:   "token-list [--json]",
+		Desc:    "Lists your personal access tokens. Secrets are never shown again after creation.",
+		MinArgs: 0,
+	}
+}
+
+func (c *tokenList) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("token-list", gnuflag.ExitOnError)
+		c.fs.BoolVar(&c.json, "json", false, "Show JSON instead of a table")
+	}
+	return c.fs
+}
+
+func (c *tokenList) Run(context *Context, client *Client) error {
+	url, err := GetURL("/users/tokens")
+	if err != nil {
+		return err
+	}
+	request, err := newTokenScopedRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var tokens []loginToken
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return err
+	}
+	if c.json {
+		return outputJSON(context.Stdout, tokens)
+	}
+	tw := tabwriter.NewWriter(context.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tDescription\tScopes\tCreated at\tExpires at")
+	for _, t := range tokens {
+		expires := "never"
+		if t.ExpiresAt != nil {
+			expires = t.ExpiresAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", t.ID, t.Description, strings.Join(t.Scopes, ", "), t.CreatedAt.Format(time.RFC3339), expires)
+	}
+	return tw.Flush()
+}
+
+type tokenRevoke struct{}
+
+func (c *tokenRevoke) Info() *Info {
+	return &Info{
+		Name:    "token-revoke",
+		Usage:   "token-revoke <token-id>",
+		Desc:    "Revokes a personal access token.",
+		MinArgs: 1,
+	}
+}
+
+func (c *tokenRevoke) Run(context *Context, client *Client) error {
+	tokenID := context.Args[0]
+	url, err := GetURL("/users/tokens/" + tokenID)
+	if err != nil {
+		return err
+	}
+	request, err := newTokenScopedRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Token %q successfully revoked!\n", tokenID)
+	return nil
+}
+
+type ssoProvider struct {
+	Name  string `json:"name"`
+	Label string `json:"label,omitempty"`
+}
+
+type ssoStartResponse struct {
+	LoginToken  string `json:"login_token"`
+	RedirectURL string `json:"redirect_url"`
+}
+
+type ssoTokenResponse struct {
+	Token string `json:"token,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+const ssoPollMaxBackoff = 10 * time.Second
+
+type loginSSO struct {
+	fs        *gnuflag.FlagSet
+	provider  string
+	timeout   string
+	noBrowser bool
+}
+
+func (c *loginSSO) Info() *Info {
+	return &Info{
+		Name:    "login-sso",
+		Usage:   "login-sso [--provider <name>] [--timeout <duration>] [--no-browser]",
+		Desc:    "Log in through a browser, brokered by an SSO provider configured on the tsuru server.",
+		MinArgs: 0,
+	}
+}
+
+func (c *loginSSO) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("login-sso", gnuflag.ExitOnError)
+		c.fs.StringVar(&c.provider, "provider", "", "The SSO provider to use, skips the interactive prompt")
+		c.fs.StringVar(&c.timeout, "timeout", "2m", "How long to wait for the login to complete")
+		c.fs.BoolVar(&c.noBrowser, "no-browser", false, "Print the login URL instead of opening a browser, for headless sessions")
+	}
+	return c.fs
+}
+
+// Run doesn't use client: like schemeInfo, the SSO handshake happens
+// before the caller has a tsuru token, so it uses plain http calls.
+func (c *loginSSO) Run(context *Context, client *Client) error {
+	timeout, err := time.ParseDuration(c.timeout)
+	if err != nil {
+		return fmt.Errorf("invalid --timeout %q: %s", c.timeout, err)
+	}
+	provider := c.provider
+	if provider == "" {
+		providersURL, err := GetURL("/auth/sso/providers")
+		if err != nil {
+			return err
+		}
+		providers, err := fetchSSOProviders(providersURL)
+		if err != nil {
+			return err
+		}
+		if len(providers) == 0 {
+			return errors.New("no SSO providers are configured on the server")
+		}
+		provider, err = chooseSSOProvider(context, providers)
+		if err != nil {
+			return err
+		}
+	}
+	startURL, err := GetURL(fmt.Sprintf("/auth/sso/%s/start", provider))
+	if err != nil {
+		return err
+	}
+	start, err := ssoStart(startURL)
+	if err != nil {
+		return err
+	}
+	if c.noBrowser {
+		fmt.Fprintf(context.Stdout, "Please open the following URL to continue login:\n%s\n", start.RedirectURL)
+	} else {
+		fmt.Fprintf(context.Stdout, "Opening browser to continue login, if it did not open please visit %q\n", start.RedirectURL)
+		open(start.RedirectURL)
+	}
+	query := url.Values{}
+	query.Set("login_token", start.LoginToken)
+	tokenURL, err := GetURL(fmt.Sprintf("/auth/sso/%s/token?%s", provider, query.Encode()))
+	if err != nil {
+		return err
+	}
+	token, err := pollSSOToken(tokenURL, timeout)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(context.Stdout, "Successfully logged in!")
+	return writeToken(token)
+}
+
+func fetchSSOProviders(url string) ([]ssoProvider, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var providers []ssoProvider
+	if err := json.NewDecoder(resp.Body).Decode(&providers); err != nil {
+		return nil, err
+	}
+	return providers, nil
+}
+
+func chooseSSOProvider(context *Context, providers []ssoProvider) (string, error) {
+	if len(providers) == 1 {
+		return providers[0].Name, nil
+	}
+	fmt.Fprintln(context.Stdout, "Choose your identity provider:")
+	for i, p := range providers {
+		fmt.Fprintf(context.Stdout, "  %d. %s\n", i+1, p.Name)
+	}
+	fmt.Fprint(context.Stdout, "> ")
+	var choice int
+	if _, err := fmt.Fscanf(context.Stdin, "%d\n", &choice); err != nil {
+		return "", err
+	}
+	if choice < 1 || choice > len(providers) {
+		return "", errors.New("invalid choice")
+	}
+	return providers[choice-1].Name, nil
+}
+
+func ssoStart(url string) (*ssoStartResponse, error) {
+	resp, err := http.Post(url, "application/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var start ssoStartResponse
+	if err := json.NewDecoder(resp.Body).Decode(&start); err != nil {
+		return nil, err
+	}
+	return &start, nil
+}
+
+// nextSSOBackoff doubles the previous backoff, capped at ssoPollMaxBackoff.
+func nextSSOBackoff(previous time.Duration) time.Duration {
+	next := previous * 2
+	if next > ssoPollMaxBackoff {
+		return ssoPollMaxBackoff
+	}
+	return next
+}
+
+// pollSSOToken polls endpoint with exponential backoff until the server
+// reports the login completed, returns an error, or timeout elapses.
+func pollSSOToken(endpoint string, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	backoff := time.Second
+	for {
+		resp, err := http.Get(endpoint)
+		if err != nil {
+			return "", err
+		}
+		var result ssoTokenResponse
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		if result.Token != "" {
+			return result.Token, nil
+		}
+		if result.Error != "" {
+			return "", errors.New(result.Error)
+		}
+		if time.Now().After(deadline) {
+			return "", errors.New("timed out waiting for SSO login")
+		}
+		time.Sleep(backoff)
+		backoff = nextSSOBackoff(backoff)
+	}
+}
@@ -0,0 +1,498 @@
+// Copyright 2014 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrimTrailingSlash(t *testing.T) {
+	cases := map[string]string{
+		"https://idp.example.com/": "https://idp.example.com",
+		"https://idp.example.com":  "https://idp.example.com",
+		"": "",
+	}
+	for in, want := range cases {
+		if got := trimTrailingSlash(in); got != want {
+			t.Errorf("trimTrailingSlash(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRandomURLSafeString(t *testing.T) {
+	a, err := randomURLSafeString(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := randomURLSafeString(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Error("expected two independently generated strings to differ")
+	}
+	if len(a) == 0 {
+		t.Error("expected a non-empty string")
+	}
+}
+
+func TestPKCEChallengeIsDeterministicAndURLSafe(t *testing.T) {
+	verifier := "some-verifier-value"
+	a := pkceChallenge(verifier)
+	b := pkceChallenge(verifier)
+	if a != b {
+		t.Errorf("pkceChallenge should be deterministic for the same verifier, got %q and %q", a, b)
+	}
+	if pkceChallenge("other-verifier") == a {
+		t.Error("pkceChallenge should differ for different verifiers")
+	}
+	if strings.ContainsAny(a, "+/=") {
+		t.Errorf("pkceChallenge should be unpadded base64url, got %q", a)
+	}
+}
+
+func TestDiscoverOIDC(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/openid-configuration" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"authorization_endpoint": "https://idp.example.com/auth",
+			"token_endpoint":         "https://idp.example.com/token",
+		})
+	}))
+	defer server.Close()
+	disc, err := discoverOIDC(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if disc.AuthorizationEndpoint != "https://idp.example.com/auth" {
+		t.Errorf("unexpected authorization_endpoint: %q", disc.AuthorizationEndpoint)
+	}
+	if disc.TokenEndpoint != "https://idp.example.com/token" {
+		t.Errorf("unexpected token_endpoint: %q", disc.TokenEndpoint)
+	}
+}
+
+func TestDiscoverOIDCError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	if _, err := discoverOIDC(server.URL); err == nil {
+		t.Fatal("expected an error for a non-200 discovery response")
+	}
+}
+
+func TestExchangeOIDCCode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if r.FormValue("code") != "the-code" || r.FormValue("code_verifier") != "the-verifier" {
+			http.Error(w, "unexpected form values", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access-123",
+			"id_token":     "id-456",
+		})
+	}))
+	defer server.Close()
+	tokenResp, err := exchangeOIDCCode(server.URL, "client-id", "the-code", "the-verifier", "http://127.0.0.1/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokenResp.AccessToken != "access-123" || tokenResp.IDToken != "id-456" {
+		t.Errorf("unexpected token response: %+v", tokenResp)
+	}
+}
+
+func TestExchangeOIDCCodeError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+	}))
+	defer server.Close()
+	if _, err := exchangeOIDCCode(server.URL, "client-id", "bad-code", "verifier", "http://127.0.0.1/callback"); err == nil {
+		t.Fatal("expected an error when the token endpoint rejects the code")
+	}
+}
+
+func TestParseOIDCSchemeSpec(t *testing.T) {
+	raw := []byte(`{"name":"oidc","data":{"issuer":"https://idp.example.com","client_id":"abc123","scopes":["openid","email"]}}`)
+	spec, err := parseOIDCSchemeSpec(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Issuer != "https://idp.example.com" || spec.ClientID != "abc123" {
+		t.Errorf("unexpected spec: %+v", spec)
+	}
+	if len(spec.Scopes) != 2 || spec.Scopes[0] != "openid" || spec.Scopes[1] != "email" {
+		t.Errorf("unexpected scopes: %v", spec.Scopes)
+	}
+}
+
+// loginScheme.Data must stay a map[string]string: other schemes (e.g.
+// "oauth") index it directly as a string map, so decoding an "oidc"
+// response (whose "scopes" is an array) must not make the whole decode
+// fail, nor change Data's static type.
+func TestLoginSchemeDataStaysStringMap(t *testing.T) {
+	var info loginScheme
+	raw := []byte(`{"name":"oidc","data":{"issuer":"https://idp.example.com","client_id":"abc123","scopes":["openid","email"]}}`)
+	err := json.Unmarshal(raw, &info)
+	if err != nil {
+		if _, ok := err.(*json.UnmarshalTypeError); !ok {
+			t.Fatalf("unexpected error decoding scheme info: %s", err)
+		}
+	}
+	if info.Name != "oidc" {
+		t.Errorf("expected name %q, got %q", "oidc", info.Name)
+	}
+	if info.Data["issuer"] != "https://idp.example.com" {
+		t.Errorf("expected Data[\"issuer\"] to be populated, got %+v", info.Data)
+	}
+	var _ map[string]string = info.Data
+}
+
+func TestParseLoginResponseReturnsToken(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	recorder.WriteHeader(http.StatusOK)
+	recorder.Body.WriteString(`{"token":"abc123"}`)
+	token, err := parseLoginResponse(recorder.Result())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "abc123" {
+		t.Errorf("expected token %q, got %q", "abc123", token)
+	}
+}
+
+func TestParseLoginResponseRejectsNonOKStatusWithoutPanicking(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	recorder.WriteHeader(http.StatusUnauthorized)
+	recorder.Body.WriteString(`{"error":"invalid credentials"}`)
+	_, err := parseLoginResponse(recorder.Result())
+	if err == nil || err.Error() != "invalid credentials" {
+		t.Errorf("expected %q, got %v", "invalid credentials", err)
+	}
+}
+
+func TestParseLoginResponseRejectsNonOKStatusWithoutErrorBody(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	recorder.WriteHeader(http.StatusInternalServerError)
+	recorder.Body.WriteString(`{}`)
+	_, err := parseLoginResponse(recorder.Result())
+	if err == nil {
+		t.Fatal("expected an error for a non-OK status without an error body")
+	}
+}
+
+func TestNewTokenScopedRequestWithoutTokenName(t *testing.T) {
+	os.Unsetenv(tsuruTokenNameEnv)
+	request, err := newTokenScopedRequest("GET", "http://example.com/users/tokens", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := request.Header.Get("Authorization"); got != "" {
+		t.Errorf("expected no Authorization header without %s set, got %q", tsuruTokenNameEnv, got)
+	}
+}
+
+func TestUserCreateRequestMarshalingEscapesSpecialCharacters(t *testing.T) {
+	req := userCreateRequest{Email: `user"with\quotes@example.com`, Password: `p"a\ss"word\`}
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded userCreateRequest
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("marshaled body isn't valid JSON: %s (body: %s)", err, b)
+	}
+	if decoded != req {
+		t.Errorf("round-trip mismatch: got %+v, want %+v", decoded, req)
+	}
+}
+
+func TestLoginRequestMarshalingEscapesSpecialCharacters(t *testing.T) {
+	req := loginRequest{Password: `p"a\ss"word\`}
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded loginRequest
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("marshaled body isn't valid JSON: %s (body: %s)", err, b)
+	}
+	if decoded != req {
+		t.Errorf("round-trip mismatch: got %+v, want %+v", decoded, req)
+	}
+}
+
+func TestTeamCreateRequestMarshalingEscapesSpecialCharacters(t *testing.T) {
+	req := teamCreateRequest{Name: `team"with\backslash`}
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded teamCreateRequest
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("marshaled body isn't valid JSON: %s (body: %s)", err, b)
+	}
+	if decoded != req {
+		t.Errorf("round-trip mismatch: got %+v, want %+v", decoded, req)
+	}
+}
+
+func TestIsCommonPasswordMatch(t *testing.T) {
+	// sha1("password") = 5baa61e4c9b93f3f0682250b6cf8331b7ee68fd8,
+	// so prefix "5BAA6" / suffix "1E4C9B93F3F0682250B6CF8331B7EE68FD8".
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("prefix") != "5BAA6" {
+			http.Error(w, "unexpected prefix", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("1E4C9B93F3F0682250B6CF8331B7EE68FD8:42\nOTHERSUFFIX:1\n"))
+	}))
+	defer server.Close()
+	common, err := isCommonPassword(server.URL, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !common {
+		t.Error("expected \"password\" to be flagged as common")
+	}
+}
+
+func TestIsCommonPasswordNoMatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OTHERSUFFIX:1\n"))
+	}))
+	defer server.Close()
+	common, err := isCommonPassword(server.URL, "a-rather-unique-passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if common {
+		t.Error("did not expect the password to be flagged as common")
+	}
+}
+
+func TestValidatePasswordWrapsCommonPasswordCheckInfraErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+	}))
+	server.Close() // closed immediately: any request to it fails to dial.
+	policy := &PasswordPolicy{CommonPasswordCheckURL: server.URL}
+	err := validatePassword(policy, "whatever-password")
+	if err == nil {
+		t.Fatal("expected an error when the common-password check is unreachable")
+	}
+	if _, ok := err.(*passwordPolicyCheckError); !ok {
+		t.Errorf("expected a *passwordPolicyCheckError, got %T: %s", err, err)
+	}
+}
+
+func TestPasswordFromReaderReturnsHardErrorWithoutLooping(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+	}))
+	server.Close()
+	policy := &PasswordPolicy{CommonPasswordCheckURL: server.URL}
+	stdout := &bytes.Buffer{}
+	// Only one password is ever available to read: if passwordFromReader
+	// looped on the infra error, it would prompt a second time and that
+	// read would fail with the empty-password error instead of returning
+	// the underlying dial error.
+	context := &Context{Stdout: stdout, Stdin: strings.NewReader("mypassword\n")}
+	_, err := passwordFromReader(context, "Password: ", policy)
+	if err == nil {
+		t.Fatal("expected a hard error, got nil")
+	}
+	if _, ok := err.(*passwordPolicyCheckError); ok {
+		t.Error("passwordFromReader should unwrap the infra error, not leak the internal wrapper type")
+	}
+	if prompts := strings.Count(stdout.String(), "Password: "); prompts != 1 {
+		t.Errorf("expected a single prompt, got %d (output: %q)", prompts, stdout.String())
+	}
+}
+
+func TestNextSSOBackoffDoublesUpToCap(t *testing.T) {
+	backoff := time.Second
+	for i := 0; i < 10; i++ {
+		backoff = nextSSOBackoff(backoff)
+		if backoff > ssoPollMaxBackoff {
+			t.Fatalf("backoff exceeded cap: %s > %s", backoff, ssoPollMaxBackoff)
+		}
+	}
+	if backoff != ssoPollMaxBackoff {
+		t.Errorf("expected backoff to have settled at the cap %s, got %s", ssoPollMaxBackoff, backoff)
+	}
+}
+
+func TestNextSSOBackoffStepsBeforeCap(t *testing.T) {
+	if got := nextSSOBackoff(time.Second); got != 2*time.Second {
+		t.Errorf("expected backoff to double to 2s, got %s", got)
+	}
+	if got := nextSSOBackoff(8 * time.Second); got != ssoPollMaxBackoff {
+		t.Errorf("expected backoff to clamp to the cap, got %s", got)
+	}
+}
+
+func TestChooseSSOProviderSingleProviderSkipsPrompt(t *testing.T) {
+	context := &Context{Stdout: &bytes.Buffer{}, Stdin: strings.NewReader("")}
+	provider, err := chooseSSOProvider(context, []ssoProvider{{Name: "google"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider != "google" {
+		t.Errorf("expected the only provider to be chosen automatically, got %q", provider)
+	}
+}
+
+func TestChooseSSOProviderPromptsAmongMultiple(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	context := &Context{Stdout: stdout, Stdin: strings.NewReader("2\n")}
+	providers := []ssoProvider{{Name: "google"}, {Name: "github"}}
+	provider, err := chooseSSOProvider(context, providers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider != "github" {
+		t.Errorf("expected the second provider to be chosen, got %q", provider)
+	}
+	if !strings.Contains(stdout.String(), "google") || !strings.Contains(stdout.String(), "github") {
+		t.Errorf("expected both providers to be listed, got %q", stdout.String())
+	}
+}
+
+func TestChooseSSOProviderRejectsOutOfRangeChoice(t *testing.T) {
+	context := &Context{Stdout: &bytes.Buffer{}, Stdin: strings.NewReader("9\n")}
+	providers := []ssoProvider{{Name: "google"}, {Name: "github"}}
+	if _, err := chooseSSOProvider(context, providers); err == nil {
+		t.Fatal("expected an error for an out-of-range choice")
+	}
+}
+
+func TestFetchSSOProviders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]ssoProvider{{Name: "google"}, {Name: "github"}})
+	}))
+	defer server.Close()
+	providers, err := fetchSSOProviders(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(providers) != 2 || providers[0].Name != "google" || providers[1].Name != "github" {
+		t.Errorf("unexpected providers: %+v", providers)
+	}
+}
+
+func TestSSOStart(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("expected POST, got %s", r.Method)
+		}
+		json.NewEncoder(w).Encode(ssoStartResponse{RedirectURL: "https://idp.example.com/auth", LoginToken: "lt123"})
+	}))
+	defer server.Close()
+	start, err := ssoStart(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start.RedirectURL != "https://idp.example.com/auth" || start.LoginToken != "lt123" {
+		t.Errorf("unexpected start response: %+v", start)
+	}
+}
+
+func TestPollSSOTokenHandshake(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			json.NewEncoder(w).Encode(ssoTokenResponse{})
+			return
+		}
+		json.NewEncoder(w).Encode(ssoTokenResponse{Token: "finaltoken"})
+	}))
+	defer server.Close()
+	token, err := pollSSOToken(server.URL, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "finaltoken" {
+		t.Errorf("expected %q, got %q", "finaltoken", token)
+	}
+	if requests != 3 {
+		t.Errorf("expected 3 polls, got %d", requests)
+	}
+}
+
+func TestPollSSOTokenReturnsServerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ssoTokenResponse{Error: "login denied"})
+	}))
+	defer server.Close()
+	_, err := pollSSOToken(server.URL, time.Minute)
+	if err == nil || err.Error() != "login denied" {
+		t.Errorf("expected %q, got %v", "login denied", err)
+	}
+}
+
+func TestPollSSOTokenTimesOutWithoutHanging(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ssoTokenResponse{})
+	}))
+	defer server.Close()
+	_, err := pollSSOToken(server.URL, 500*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected a timeout error")
+	}
+}
+
+func TestOutputJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	users := []userInfo{
+		{Email: "a@example.com", Status: UserStatusActive, Teams: []string{"t1"}},
+		{Email: "b@example.com", Status: UserStatusSuspended},
+	}
+	if err := outputJSON(buf, users); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []userInfo
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("outputJSON didn't produce valid JSON: %s (body: %s)", err, buf.String())
+	}
+	if len(decoded) != 2 || decoded[0].Email != users[0].Email || decoded[1].Status != UserStatusSuspended {
+		t.Errorf("unexpected round-trip result: %+v", decoded)
+	}
+}
+
+func TestUserInfoJSONRoundTrip(t *testing.T) {
+	u := userInfo{
+		Email:  "someone@example.com",
+		Status: UserStatusActive,
+		Teams:  []string{"team-a", "team-b"},
+		Roles:  []string{"admin"},
+	}
+	b, err := json.Marshal(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded userInfo
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Email != u.Email || decoded.Status != u.Status || len(decoded.Teams) != 2 || len(decoded.Roles) != 1 {
+		t.Errorf("unexpected round-trip result: %+v", decoded)
+	}
+}